@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "proxy_requests_total",
+		Help: "Total number of proxied requests.",
+	}, []string{"host", "code"})
+
+	upstreamLatencySeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "proxy_upstream_latency_seconds",
+		Help: "Latency of the backend round trip, per host.",
+	}, []string{"host"})
+)
+
+// accessLogEntry is one JSON line of the access log.
+type accessLogEntry struct {
+	Time              string  `json:"time"`
+	Method            string  `json:"method"`
+	Host              string  `json:"host"`
+	Path              string  `json:"path"`
+	Query             string  `json:"query,omitempty"`
+	RemoteAddr        string  `json:"remoteAddr"`
+	XFF               string  `json:"xff,omitempty"`
+	TLSServerName     string  `json:"tlsServerName,omitempty"`
+	Status            int     `json:"status"`
+	BytesWritten      int64   `json:"bytesWritten"`
+	UpstreamLatencyMs float64 `json:"upstreamLatencyMs"`
+	TotalLatencyMs    float64 `json:"totalLatencyMs"`
+}
+
+// logAccess writes one JSON line to the standard logger and records the
+// proxy_requests_total/proxy_upstream_latency_seconds metrics.
+func logAccess(r *http.Request, status int, bytesWritten int64, upstreamLatency, totalLatency time.Duration) {
+	var sni string
+	if r.TLS != nil {
+		sni = r.TLS.ServerName
+	}
+	e := accessLogEntry{
+		Time:              time.Now().Format(time.RFC3339Nano),
+		Method:            r.Method,
+		Host:              r.Host,
+		Path:              r.URL.Path,
+		Query:             r.URL.RawQuery,
+		RemoteAddr:        r.RemoteAddr,
+		XFF:               r.Header.Get("X-Forwarded-For"),
+		TLSServerName:     sni,
+		Status:            status,
+		BytesWritten:      bytesWritten,
+		UpstreamLatencyMs: float64(upstreamLatency) / float64(time.Millisecond),
+		TotalLatencyMs:    float64(totalLatency) / float64(time.Millisecond),
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("marshal access log entry: %+v", err)
+		return
+	}
+	log.Print(string(b))
+
+	requestsTotal.WithLabelValues(r.Host, strconv.Itoa(status)).Inc()
+	upstreamLatencySeconds.WithLabelValues(r.Host).Observe(upstreamLatency.Seconds())
+}
+
+// serveAdmin exposes Prometheus metrics on a listener bound to localhost,
+// so that the admin surface is never reachable from outside the host. It
+// returns an error if addr's host is not "localhost" or a loopback IP.
+func serveAdmin(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("admin addr %q: %w", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("admin addr %q is not a loopback address", addr)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	return server.ListenAndServe()
+}
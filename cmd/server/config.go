@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/fumin/proxy/gcscache"
+)
+
+// config is the on-disk representation of the proxy's settings.
+// It is loaded once at startup, and re-loaded whenever the process
+// receives SIGHUP, so that operators can add or remove backends and
+// redirects without restarting the proxy.
+type config struct {
+	Email string `json:"email"`
+	// Cache is where autocert stores certificates, as a URI. Supported
+	// schemes are "dir://" for a local directory, e.g. "dir:///var/cert",
+	// and "gs://" for a Google Cloud Storage bucket, e.g. "gs://my-bucket".
+	Cache string `json:"cache"`
+	// Hosts is the list of hostnames autocert is allowed to issue
+	// certificates for.
+	Hosts []string `json:"hosts"`
+
+	// Redirects maps a hostname to the hostname it should redirect to.
+	Redirects map[string]string `json:"redirects"`
+	// Backends maps a hostname to its ordered list of path-prefix routes.
+	Backends map[string][]backendRoute `json:"backends"`
+	// IPFilters maps a hostname to the IP allow/deny policy guarding it.
+	// Hosts absent from this map are not filtered.
+	IPFilters map[string]ipFilterRule `json:"ipFilters"`
+	// ForwardProxy configures the optional CONNECT tunneling mode. It is
+	// disabled, i.e. refuses every CONNECT, when AllowedHosts is empty.
+	ForwardProxy forwardProxyConfig `json:"forwardProxy"`
+	// AdminAddr is the localhost address the Prometheus /metrics endpoint
+	// is served on, e.g. "127.0.0.1:9090". The admin listener is disabled
+	// if this is empty.
+	AdminAddr string `json:"adminAddr"`
+}
+
+// loadConfig reads and parses the config file at path.
+func loadConfig(path string) (*config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// hostPolicy is a reloadable autocert.HostPolicy: certManager installs its
+// Allow method on the autocert.Manager once, and reload swaps in a new host
+// whitelist via atomic.Value, rather than reassigning Manager.HostPolicy
+// itself, which would race with concurrent TLS handshakes reading it.
+type hostPolicy struct {
+	whitelist atomic.Value // autocert.HostPolicy
+}
+
+func newHostPolicy(hosts []string) *hostPolicy {
+	hp := &hostPolicy{}
+	hp.set(hosts)
+	return hp
+}
+
+// set atomically swaps in a new host whitelist.
+func (hp *hostPolicy) set(hosts []string) {
+	hp.whitelist.Store(autocert.HostWhitelist(hosts...))
+}
+
+// Allow implements autocert.HostPolicy.
+func (hp *hostPolicy) Allow(ctx context.Context, host string) error {
+	return hp.whitelist.Load().(autocert.HostPolicy)(ctx, host)
+}
+
+// certManager builds an autocert.Manager from the config, whose HostPolicy
+// is backed by hp so that reload can update it atomically.
+func (c *config) certManager(ctx context.Context, hp *hostPolicy) (*autocert.Manager, error) {
+	cache, err := newAutocertCache(ctx, c.Cache)
+	if err != nil {
+		return nil, fmt.Errorf("newAutocertCache: %w", err)
+	}
+
+	return &autocert.Manager{
+		HostPolicy: hp.Allow,
+		Email:      c.Email,
+		Cache:      cache,
+		Prompt:     autocert.AcceptTOS,
+	}, nil
+}
+
+// newAutocertCache builds an autocert.Cache from a "dir://" or "gs://" URI.
+func newAutocertCache(ctx context.Context, uri string) (autocert.Cache, error) {
+	switch {
+	case strings.HasPrefix(uri, "dir://"):
+		return autocert.DirCache(strings.TrimPrefix(uri, "dir://")), nil
+	case strings.HasPrefix(uri, "gs://"):
+		bucket := strings.TrimPrefix(uri, "gs://")
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("storage.NewClient: %w", err)
+		}
+		return gcscache.New(client, bucket), nil
+	default:
+		return nil, fmt.Errorf("unsupported cache uri %q, want dir:// or gs://", uri)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// forwardProxyConfig is the config representation of the optional
+// forward-proxy mode: handling CONNECT requests to tunnel TLS to a
+// whitelisted set of hosts.
+type forwardProxyConfig struct {
+	// AllowedHosts is the list of "host:port" authorities CONNECT is
+	// allowed to tunnel to. CONNECT is refused entirely if this is empty.
+	AllowedHosts []string `json:"allowedHosts"`
+}
+
+// forwardProxy implements HTTP CONNECT tunneling to a fixed set of hosts.
+type forwardProxy struct {
+	allowed map[string]bool
+}
+
+func buildForwardProxy(cfg forwardProxyConfig) *forwardProxy {
+	allowed := make(map[string]bool, len(cfg.AllowedHosts))
+	for _, h := range cfg.AllowedHosts {
+		allowed[h] = true
+	}
+	return &forwardProxy{allowed: allowed}
+}
+
+// serveConnect handles a CONNECT request by dialing r.Host and splicing
+// bytes bidirectionally between the client and that connection, the same
+// tunnel a forward proxy sets up for HTTPS.
+func (fp *forwardProxy) serveConnect(w http.ResponseWriter, r *http.Request) {
+	if !fp.allowed[r.Host] {
+		http.Error(w, "host not allowed", http.StatusForbidden)
+		return
+	}
+
+	destConn, err := net.DialTimeout("tcp", r.Host, 10*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer destConn.Close()
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, _, err := hj.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer clientConn.Close()
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(destConn, clientConn)
+		close(done)
+	}()
+	io.Copy(clientConn, destConn)
+	<-done
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+)
+
+// TestForwardProxyConnect drives a real CONNECT request through
+// reverseProxy wrapped in gziphandler.GzipHandler, exactly as serveTLS wires
+// it in main.go: an allowed host's tunnel must splice bytes through to it,
+// and a host missing from AllowedHosts must be rejected with 403. Wrapping
+// in gzip here matters because the hijack depends on the wrapping
+// ResponseWriter still implementing http.Hijacker.
+func TestForwardProxyConnect(t *testing.T) {
+	targetLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen target: %v", err)
+	}
+	defer targetLn.Close()
+	go func() {
+		conn, err := targetLn.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn) // echo
+	}()
+
+	target := targetLn.Addr().String()
+	fp := buildForwardProxy(forwardProxyConfig{AllowedHosts: []string{target}})
+	rp := newReverseProxy(nil, nil, nil, fp)
+	front := httptest.NewServer(gziphandler.GzipHandler(rp))
+	defer front.Close()
+	frontAddr := strings.TrimPrefix(front.URL, "http://")
+
+	t.Run("allowed", func(t *testing.T) {
+		conn, err := net.Dial("tcp", frontAddr)
+		if err != nil {
+			t.Fatalf("dial front: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\nAccept-Encoding: gzip\r\n\r\n", target, target)
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+
+		want := []byte("ping")
+		if _, err := conn.Write(want); err != nil {
+			t.Fatalf("write tunnel payload: %v", err)
+		}
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(br, got); err != nil {
+			t.Fatalf("read tunnel echo: %v", err)
+		}
+		if string(got) != string(want) {
+			t.Fatalf("echo = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		conn, err := net.Dial("tcp", frontAddr)
+		if err != nil {
+			t.Fatalf("dial front: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		denied := "127.0.0.1:1"
+		fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\nAccept-Encoding: gzip\r\n\r\n", denied, denied)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBuildIPFiltersInvalidCIDR(t *testing.T) {
+	if _, err := buildIPFilters(map[string]ipFilterRule{"h": {Allow: []string{"not-a-cidr"}}}); err == nil {
+		t.Fatal("buildIPFilters: want error for invalid allow CIDR, got nil")
+	}
+	if _, err := buildIPFilters(map[string]ipFilterRule{"h": {Deny: []string{"not-a-cidr"}}}); err == nil {
+		t.Fatal("buildIPFilters: want error for invalid deny CIDR, got nil")
+	}
+}
+
+func TestIPFilterAllowed(t *testing.T) {
+	filters, err := buildIPFilters(map[string]ipFilterRule{
+		"remote.example": {Allow: []string{"10.0.0.0/8"}},
+		"deny.example":   {Allow: []string{"10.0.0.0/8"}, Deny: []string{"10.0.0.1/32"}},
+		"open.example":   {},
+		"xff.example":    {Allow: []string{"192.168.1.0/24"}, Source: "xff"},
+		"both.example":   {Allow: []string{"10.0.0.0/8"}, Source: "both"},
+	})
+	if err != nil {
+		t.Fatalf("buildIPFilters: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		host       string
+		remoteAddr string
+		xff        string
+		wantOK     bool
+		wantAddr   string
+	}{
+		{"remoteAddr in allow list", "remote.example", "10.1.2.3:1234", "", true, "10.1.2.3"},
+		{"remoteAddr not in allow list", "remote.example", "8.8.8.8:1234", "", false, "8.8.8.8"},
+		{"deny takes precedence over allow", "deny.example", "10.0.0.1:1234", "", false, "10.0.0.1"},
+		{"deny does not affect other allowed addresses", "deny.example", "10.0.0.2:1234", "", true, "10.0.0.2"},
+		{"no allow list permits anything not denied", "open.example", "1.2.3.4:1234", "", true, "1.2.3.4"},
+
+		{"xff mode trusts the right-most hop", "xff.example", "1.2.3.4:1", "8.8.8.8, 192.168.1.50", true, "192.168.1.50"},
+		{"xff mode rejects a spoofed left-most hop", "xff.example", "1.2.3.4:1", "192.168.1.50, 8.8.8.8", false, "8.8.8.8"},
+		{"xff mode rejects when XFF is absent", "xff.example", "1.2.3.4:1", "", false, ""},
+
+		{"both mode requires the XFF hop too", "both.example", "10.5.5.5:1", "8.8.8.8", false, "8.8.8.8"},
+		{"both mode fails fast on RemoteAddr", "both.example", "8.8.8.8:1", "10.5.5.5", false, "8.8.8.8"},
+		{"both mode rejects when XFF is absent", "both.example", "10.5.5.5:1", "", false, ""},
+		{"both mode passes when both addresses are allowed", "both.example", "10.5.5.5:1", "10.9.9.9", true, "10.9.9.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &http.Request{RemoteAddr: tt.remoteAddr, Header: http.Header{}}
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+
+			ok, addr, _ := filters[tt.host].allowed(r)
+			if ok != tt.wantOK {
+				t.Errorf("allowed = %v, want %v", ok, tt.wantOK)
+			}
+			if addr != tt.wantAddr {
+				t.Errorf("addr = %q, want %q", addr, tt.wantAddr)
+			}
+		})
+	}
+}
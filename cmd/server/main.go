@@ -1,51 +1,61 @@
 // Command proxy serves as a user facing reverse proxies for backend servers.
-// Edit the configuration variables in mainWithErr to customize.
+// Customize it by pointing the -config flag at a JSON config file; see
+// config.go for its format. Sending the process SIGHUP reloads the file.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"html"
 	"log"
 	"net/http"
 	"net/http/httputil"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/NYTimes/gziphandler"
 	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
 )
 
+var configPath = flag.String("config", "/etc/proxy/config.json", "path to the proxy's JSON config file")
+
 func mainWithErr() error {
-	// Configuration variables.
-	//
-	// email, cache, and policy configure autocert.Manager.
-	email := "my@email.com"
-	var cache autocert.Cache = autocert.DirCache("/var/cert")
-	var policy autocert.HostPolicy = autocert.HostWhitelist(
-		"my.domain",
-		"www.my.domain",
-		"xyz.my.domain",
-	)
-
-	// redirects specify the redirect mappings.
-	redirects := map[string]string{
-		"my.domain": "www.my.domain",
-	}
-
-	// backends specify the reverse proxy backends.
-	backends := map[string]string{
-		"www.my.domain": "localhost:12345",
-		"xyz.my.domain": "localhost:22345",
-	}
-
-	certMng := &autocert.Manager{
-		HostPolicy: policy,
-		Email:      email,
-		Cache:      cache,
-		Prompt:     autocert.AcceptTOS,
+	ctx := context.Background()
+
+	c, err := loadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("loadConfig: %w", err)
+	}
+
+	hp := newHostPolicy(c.Hosts)
+	certMng, err := c.certManager(ctx, hp)
+	if err != nil {
+		return fmt.Errorf("certManager: %w", err)
+	}
+	backends, err := buildRoutes(c.Backends)
+	if err != nil {
+		return fmt.Errorf("buildRoutes: %w", err)
+	}
+	ipFilters, err := buildIPFilters(c.IPFilters)
+	if err != nil {
+		return fmt.Errorf("buildIPFilters: %w", err)
 	}
+	fp := buildForwardProxy(c.ForwardProxy)
+	rp := newReverseProxy(c.Redirects, backends, ipFilters, fp)
+
+	// Reload the config on SIGHUP, atomically swapping the redirect and
+	// backend tables and the autocert host policy, without dropping any
+	// in-flight connections.
+	go watchReload(hp, rp)
+
 	// Start proxy.
 	go func() {
-		if err := serveTLS(certMng, redirects, backends); err != nil {
+		if err := serveTLS(certMng, rp); err != nil {
 			log.Fatalf("%+v", err)
 		}
 	}()
@@ -55,14 +65,54 @@ func mainWithErr() error {
 			log.Fatalf("%+v", err)
 		}
 	}()
+	// Serve Prometheus metrics on localhost.
+	if c.AdminAddr != "" {
+		go func() {
+			if err := serveAdmin(c.AdminAddr); err != nil {
+				log.Fatalf("%+v", err)
+			}
+		}()
+	}
 
 	log.Printf("proxy running")
 	select {}
 }
 
-// responseWriter is similar to http.ResponseWriter, except that it saves the status code.
+// watchReload re-reads the config file every time the process receives
+// SIGHUP, and swaps the new settings into hp and rp.
+func watchReload(hp *hostPolicy, rp *reverseProxy) {
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGHUP)
+	for range sigC {
+		c, err := loadConfig(*configPath)
+		if err != nil {
+			log.Printf("reload config: %+v", err)
+			continue
+		}
+
+		backends, err := buildRoutes(c.Backends)
+		if err != nil {
+			log.Printf("reload config: %+v", err)
+			continue
+		}
+		ipFilters, err := buildIPFilters(c.IPFilters)
+		if err != nil {
+			log.Printf("reload config: %+v", err)
+			continue
+		}
+		fp := buildForwardProxy(c.ForwardProxy)
+
+		hp.set(c.Hosts)
+		rp.setRoutes(c.Redirects, backends, ipFilters, fp)
+		log.Printf("config reloaded from %s", *configPath)
+	}
+}
+
+// responseWriter is similar to http.ResponseWriter, except that it saves
+// the status code and the number of bytes written.
 type responseWriter struct {
 	status int
+	bytes  int64
 	http.ResponseWriter
 }
 
@@ -71,43 +121,97 @@ func (w *responseWriter) WriteHeader(status int) {
 	w.ResponseWriter.WriteHeader(status)
 }
 
-// reverseProxy is an HTTP handler.
+func (w *responseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// routes holds the redirect, backend, and IP-filter tables as a single
+// value, so that they can be swapped atomically on reload without the
+// director or ServeHTTP ever observing a half-updated set of maps.
+type routes struct {
+	redirects    map[string]string
+	backends     map[string]hostRoutes
+	ipFilters    map[string]*ipFilter
+	forwardProxy *forwardProxy
+}
+
+// reverseProxy is an HTTP handler. WebSocket upgrades are detected and
+// spliced through to the backend explicitly, in serveWebSocketUpgrade,
+// rather than relying on httputil.ReverseProxy's own upgrade handling.
 type reverseProxy struct {
-	redirects map[string]string
-	backends  map[string]string
-	proxy     *httputil.ReverseProxy
+	routes atomic.Value // routes
+	proxy  *httputil.ReverseProxy
 }
 
-func newReverseProxy(redirects, backends map[string]string) *reverseProxy {
-	director := func(r *http.Request) {
-		r.URL.Scheme = "http"
-		r.URL.Host = backends[r.Host]
-	}
+func newReverseProxy(redirects map[string]string, backends map[string]hostRoutes, ipFilters map[string]*ipFilter, fp *forwardProxy) *reverseProxy {
+	rp := &reverseProxy{}
+	rp.setRoutes(redirects, backends, ipFilters, fp)
+	rp.proxy = &httputil.ReverseProxy{Director: rp.director, Transport: newBackendTransport()}
+	return rp
+}
+
+// setRoutes atomically swaps in a new redirect/backend/IP-filter/forward-proxy table.
+func (rp *reverseProxy) setRoutes(redirects map[string]string, backends map[string]hostRoutes, ipFilters map[string]*ipFilter, fp *forwardProxy) {
+	rp.routes.Store(routes{redirects: redirects, backends: backends, ipFilters: ipFilters, forwardProxy: fp})
+}
 
-	rp := &reverseProxy{
-		redirects: redirects,
-		backends:  backends,
-		proxy:     &httputil.ReverseProxy{Director: director},
+// director rewrites r to target the route ServeHTTP already matched and
+// attached to r's context. It does not re-resolve the route itself: doing
+// so would let a config reload landing between ServeHTTP's match and this
+// call send the request to a different backend, or to none at all.
+func (rp *reverseProxy) director(r *http.Request) {
+	rte, ok := routeFromContext(r.Context())
+	if !ok {
+		return
 	}
-	return rp
+	rte.rewrite(r)
 }
 
 func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt := rp.routes.Load().(routes)
+
+	// Forward proxy: CONNECT tunnels straight through to its target,
+	// bypassing the redirect/backend/IP-filter tables below, which only
+	// apply to the reverse-proxy side of this binary.
+	if r.Method == http.MethodConnect {
+		rt.forwardProxy.serveConnect(w, r)
+		return
+	}
+
+	// IP filter.
+	if f, ok := rt.ipFilters[r.Host]; ok {
+		if allow, addr, rule := f.allowed(r); !allow {
+			log.Printf("reject %s %s: %s", addr, r.Host, rule)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
 	// Redirect.
-	if ok := redirectByHost(rp.redirects, w, r); ok {
+	if ok := redirectByHost(rt.redirects, w, r); ok {
 		return
 	}
 
 	// Proxy.
 	host := r.Host
-	if _, ok := rp.backends[host]; ok {
+	if rte, ok := rt.backends[host].match(r.URL.Path); ok {
+		if isWebSocketUpgrade(r) {
+			serveWebSocketUpgrade(w, r, rte)
+			return
+		}
+
+		ctx, upstreamLatency := withUpstreamLatency(r.Context())
+		ctx = withRoute(ctx, rte)
+		r = r.WithContext(ctx)
+
 		respW := &responseWriter{ResponseWriter: w}
+		start := time.Now()
 		rp.proxy.ServeHTTP(respW, r)
+		totalLatency := time.Since(start)
 
-		urlStr := fmt.Sprintf("%s%s?%s", r.Host, r.URL.EscapedPath(), r.URL.RawQuery)
-		if respW.status != 200 {
-			log.Printf("%s %s %d", r.Method, urlStr, respW.status)
-		}
+		logAccess(r, respW.status, respW.bytes, *upstreamLatency, totalLatency)
 		return
 	}
 
@@ -115,13 +219,18 @@ func (rp *reverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(fmt.Sprintf("unknown host: %s", host)))
 }
 
-func serveTLS(certMng *autocert.Manager, redirects, backends map[string]string) error {
-	handler := newReverseProxy(redirects, backends)
-	withGz := gziphandler.GzipHandler(handler)
+func serveTLS(certMng *autocert.Manager, rp *reverseProxy) error {
+	withGz := gziphandler.GzipHandler(rp)
 	server := &http.Server{
 		Addr:    ":443",
 		Handler: withGz,
 	}
+	// certMng.Listener serves raw TLS connections, bypassing the net/http2
+	// setup that ListenAndServeTLS normally does for us, so advertise h2
+	// via ALPN explicitly.
+	if err := http2.ConfigureServer(server, &http2.Server{}); err != nil {
+		return fmt.Errorf("http2.ConfigureServer: %w", err)
+	}
 	return server.Serve(certMng.Listener())
 }
 
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// backendRoute is one entry of a host's routing table: requests whose path
+// starts with Path are proxied to Target, optionally stripping the matched
+// prefix and rewriting the outgoing Host header.
+type backendRoute struct {
+	// Path is the URL path prefix this route matches, e.g. "/api".
+	Path string `json:"path"`
+	// Target is the upstream URL, including scheme, e.g.
+	// "https://localhost:12345" or "h2c://localhost:12345".
+	Target string `json:"target"`
+	// StripPrefix, if set, is removed from the front of the request path
+	// before it is forwarded to Target.
+	StripPrefix string `json:"stripPrefix"`
+	// RewriteHost, if set, replaces the outgoing Host header.
+	RewriteHost string `json:"rewriteHost"`
+}
+
+// route is a backendRoute with its target pre-parsed.
+type route struct {
+	prefix      string
+	target      *url.URL
+	stripPrefix string
+	rewriteHost string
+}
+
+// rewrite points r at rte's target: it sets the outgoing scheme and host,
+// strips rte.stripPrefix from the path if configured, and replaces the
+// Host header if rte.rewriteHost is set. It is shared by director and the
+// WebSocket tunnel, so both rewrite a matched request the same way.
+func (rte route) rewrite(r *http.Request) {
+	r.URL.Scheme = rte.target.Scheme
+	r.URL.Host = rte.target.Host
+	if rte.stripPrefix != "" {
+		path := strings.TrimPrefix(r.URL.Path, rte.stripPrefix)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		r.URL.Path = path
+	}
+	if rte.rewriteHost != "" {
+		r.Host = rte.rewriteHost
+	}
+}
+
+// routeCtxKeyType is the context key ServeHTTP uses to pass the route it
+// matched to director, so that a config reload landing between the two
+// can't make director resolve a different (or no) route than the one
+// ServeHTTP decided to proxy.
+type routeCtxKeyType struct{}
+
+var routeCtxKey routeCtxKeyType
+
+// withRoute attaches rte to ctx for director to pick up.
+func withRoute(ctx context.Context, rte route) context.Context {
+	return context.WithValue(ctx, routeCtxKey, rte)
+}
+
+// routeFromContext retrieves the route withRoute attached to ctx.
+func routeFromContext(ctx context.Context) (route, bool) {
+	rte, ok := ctx.Value(routeCtxKey).(route)
+	return rte, ok
+}
+
+// hostRoutes is a host's routing table, sorted longest-prefix-first so that
+// the first matching entry is always the most specific one.
+type hostRoutes []route
+
+// match returns the first route whose prefix matches path, similar to how
+// frp's httpReverseProxy resolves a (domain, location) pair to a backend.
+// A prefix only matches at a path segment boundary, so a route for "/api"
+// does not also capture "/apisecret".
+func (hrs hostRoutes) match(path string) (route, bool) {
+	for _, r := range hrs {
+		if r.prefix == "/" || path == r.prefix || strings.HasPrefix(path, r.prefix+"/") {
+			return r, true
+		}
+	}
+	return route{}, false
+}
+
+// buildRoutes parses each host's list of backendRoutes into a sorted
+// hostRoutes table.
+func buildRoutes(raw map[string][]backendRoute) (map[string]hostRoutes, error) {
+	built := make(map[string]hostRoutes, len(raw))
+	for host, brs := range raw {
+		hrs := make(hostRoutes, 0, len(brs))
+		for _, br := range brs {
+			// match appends "/" to the prefix to enforce a segment boundary,
+			// so a configured prefix that already ends in "/" (other than
+			// the "/" catch-all itself) would need a double slash to match
+			// anything below it. Reject that instead of silently never
+			// matching.
+			if br.Path != "/" && strings.HasSuffix(br.Path, "/") {
+				return nil, fmt.Errorf("host %q: path %q must not end in \"/\"", host, br.Path)
+			}
+
+			target, err := url.Parse(br.Target)
+			if err != nil {
+				return nil, fmt.Errorf("host %q: parse target %q: %w", host, br.Target, err)
+			}
+			hrs = append(hrs, route{
+				prefix:      br.Path,
+				target:      target,
+				stripPrefix: br.StripPrefix,
+				rewriteHost: br.RewriteHost,
+			})
+		}
+		sort.SliceStable(hrs, func(i, j int) bool {
+			return len(hrs[i].prefix) > len(hrs[j].prefix)
+		})
+		built[host] = hrs
+	}
+	return built, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHostRoutesMatch(t *testing.T) {
+	hrs, err := buildRoutes(map[string][]backendRoute{
+		"example.com": {
+			{Path: "/", Target: "http://root"},
+			{Path: "/api", Target: "http://api"},
+			{Path: "/api/v2", Target: "http://api-v2"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("buildRoutes: %v", err)
+	}
+	hr := hrs["example.com"]
+
+	tests := []struct {
+		path       string
+		wantTarget string
+		wantOK     bool
+	}{
+		{"/api", "http://api", true},
+		{"/api/users", "http://api", true},
+		{"/apisecret", "http://root", true}, // must NOT match "/api": no segment boundary
+		{"/api/v2", "http://api-v2", true},  // longest prefix wins over "/api"
+		{"/api/v2/foo", "http://api-v2", true},
+		{"/", "http://root", true},
+		{"/anything/else", "http://root", true}, // "/" is the catch-all
+	}
+	for _, tt := range tests {
+		rte, ok := hr.match(tt.path)
+		if ok != tt.wantOK {
+			t.Errorf("match(%q) ok = %v, want %v", tt.path, ok, tt.wantOK)
+			continue
+		}
+		if ok && rte.target.String() != tt.wantTarget {
+			t.Errorf("match(%q) target = %q, want %q", tt.path, rte.target.String(), tt.wantTarget)
+		}
+	}
+}
+
+func TestHostRoutesMatchNoCatchAll(t *testing.T) {
+	hrs, err := buildRoutes(map[string][]backendRoute{
+		"example.com": {{Path: "/api", Target: "http://api"}},
+	})
+	if err != nil {
+		t.Fatalf("buildRoutes: %v", err)
+	}
+	if _, ok := hrs["example.com"].match("/other"); ok {
+		t.Errorf("match(%q) = ok, want no match without a catch-all route", "/other")
+	}
+}
+
+func TestBuildRoutesRejectsTrailingSlashPrefix(t *testing.T) {
+	_, err := buildRoutes(map[string][]backendRoute{
+		"example.com": {{Path: "/api/", Target: "http://api"}},
+	})
+	if err == nil {
+		t.Fatal("buildRoutes: want error for a path ending in \"/\", got nil")
+	}
+}
+
+func TestRouteRewrite(t *testing.T) {
+	tests := []struct {
+		name        string
+		stripPrefix string
+		rewriteHost string
+		path        string
+		wantPath    string
+		wantHost    string
+	}{
+		{"no stripPrefix", "", "", "/api/users", "/api/users", ""},
+		{"stripPrefix leaves a path", "/api", "", "/api/users", "/users", ""},
+		{"stripPrefix to empty result becomes /", "/api", "", "/api", "/", ""},
+		{"rewriteHost replaces the Host header", "", "backend.internal", "/api", "/api", "backend.internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rte := route{
+				prefix:      "/api",
+				target:      mustParseURL(t, "http://backend:8080"),
+				stripPrefix: tt.stripPrefix,
+				rewriteHost: tt.rewriteHost,
+			}
+			r := &http.Request{URL: mustParseURL(t, "http://example.com"+tt.path), Host: "example.com"}
+			rte.rewrite(r)
+
+			if r.URL.Path != tt.wantPath {
+				t.Errorf("path = %q, want %q", r.URL.Path, tt.wantPath)
+			}
+			wantHost := tt.wantHost
+			if wantHost == "" {
+				wantHost = "example.com"
+			}
+			if r.Host != wantHost {
+				t.Errorf("host = %q, want %q", r.Host, wantHost)
+			}
+			if r.URL.Scheme != "http" || r.URL.Host != "backend:8080" {
+				t.Errorf("scheme/host = %s/%s, want http/backend:8080", r.URL.Scheme, r.URL.Host)
+			}
+		})
+	}
+}
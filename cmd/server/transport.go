@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// upstreamLatencyKey is the context key under which the duration of the
+// backend round trip is recorded, for the access log and metrics to pick
+// up after httputil.ReverseProxy has finished serving the request.
+type upstreamLatencyKeyType struct{}
+
+var upstreamLatencyKey upstreamLatencyKeyType
+
+// withUpstreamLatency attaches a *time.Duration to ctx that backendTransport
+// fills in once the backend round trip completes.
+func withUpstreamLatency(ctx context.Context) (context.Context, *time.Duration) {
+	d := new(time.Duration)
+	return context.WithValue(ctx, upstreamLatencyKey, d), d
+}
+
+// backendTransport dispatches each request to an upstream through
+// http.DefaultTransport (for "http://" and "https://" targets), or, for
+// routes whose target scheme is "h2c://", over cleartext HTTP/2, so that backends which themselves speak h2c don't have to be
+// downgraded to HTTP/1.1 just because the proxy's TLS frontend is the only
+// part that negotiates HTTP/2.
+type backendTransport struct {
+	http1 http.RoundTripper
+	h2c   *http2.Transport
+}
+
+func newBackendTransport() *backendTransport {
+	return &backendTransport{
+		http1: http.DefaultTransport,
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+	}
+}
+
+func (t *backendTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.roundTrip(r)
+	if d, ok := r.Context().Value(upstreamLatencyKey).(*time.Duration); ok {
+		*d = time.Since(start)
+	}
+	return resp, err
+}
+
+func (t *backendTransport) roundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Scheme != "h2c" {
+		return t.http1.RoundTrip(r)
+	}
+
+	r2 := r.Clone(r.Context())
+	r2.URL.Scheme = "http"
+	return t.h2c.RoundTrip(r2)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// isWebSocketUpgrade reports whether r asks to upgrade the connection to
+// the "websocket" protocol (RFC 6455): a "Connection: Upgrade" header,
+// whose value is a comma-separated list of tokens, together with
+// "Upgrade: websocket".
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header.Get("Connection"), "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+func headerHasToken(header, token string) bool {
+	for _, v := range strings.Split(header, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveWebSocketUpgrade dials rte's backend directly, forwards the original
+// upgrade request to it, then hijacks the client connection and splices it
+// bidirectionally with the backend for the lifetime of the WebSocket
+// connection. It bypasses httputil.ReverseProxy entirely, since a
+// reused *http.Transport has no notion of handing a hijacked connection
+// back to its caller.
+func serveWebSocketUpgrade(w http.ResponseWriter, r *http.Request, rte route) {
+	rte.rewrite(r)
+
+	var backendConn net.Conn
+	var err error
+	if rte.target.Scheme == "https" {
+		backendConn, err = tls.Dial("tcp", r.URL.Host, &tls.Config{ServerName: rte.target.Hostname()})
+	} else {
+		backendConn, err = net.Dial("tcp", r.URL.Host)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer backendConn.Close()
+
+	if err := r.Write(backendConn); err != nil {
+		http.Error(w, fmt.Sprintf("forward upgrade request: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, _, err := hj.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer clientConn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(backendConn, clientConn)
+		close(done)
+	}()
+	io.Copy(clientConn, backendConn)
+	<-done
+}
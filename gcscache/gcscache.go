@@ -0,0 +1,64 @@
+// Package gcscache implements autocert.Cache on top of a Google Cloud
+// Storage bucket, so that a proxy built from this module can run as a
+// stateless container or VM without a persistent certificate volume.
+package gcscache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var _ autocert.Cache = (*Cache)(nil)
+
+// Cache stores autocert's certificates and account keys as objects in a
+// single GCS bucket, one object per cache key.
+type Cache struct {
+	bucket *storage.BucketHandle
+}
+
+// New returns a Cache backed by the named bucket.
+func New(client *storage.Client, bucket string) *Cache {
+	return &Cache{bucket: client.Bucket(bucket)}
+}
+
+// Get implements autocert.Cache.
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
+	r, err := c.bucket.Object(key).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, autocert.ErrCacheMiss
+	} else if err != nil {
+		return nil, fmt.Errorf("new reader: %w", err)
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	return b, nil
+}
+
+// Put implements autocert.Cache.
+func (c *Cache) Put(ctx context.Context, key string, data []byte) error {
+	w := c.bucket.Object(key).NewWriter(ctx)
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		w.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+	return w.Close()
+}
+
+// Delete implements autocert.Cache.
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	err := c.bucket.Object(key).Delete(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil
+	}
+	return err
+}